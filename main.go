@@ -1,15 +1,38 @@
+// Command travisqueue keeps at most N builds running per branch on CI,
+// where N defaults to 1 and can be raised per branch via
+// ONEBUILD_CONCURRENCY: when a build starts, it cancels itself if it finds
+// N earlier-started builds still running or a newer build that already
+// finished; when a build finishes, it restarts the newest queued build if
+// that build was canceled to make way for it and doing so wouldn't exceed
+// the limit.
+//
+// In a monorepo, ONEBUILD_PATHS further splits each branch's queue by which
+// of its ordered glob patterns a build's changed files match, so builds
+// touching unrelated subtrees (e.g. "sdk/**" vs. "docs/**") never cancel
+// each other; see pathGroup.
+//
+// It supports Travis CI, GitHub Actions, and LUCI Buildbucket, selected via
+// TRAVISQUEUE_PROVIDER (default "travis"); see pkg/provider.
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
-	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/pulumi/travisqueue/pkg/provider"
+	"github.com/pulumi/travisqueue/pkg/provider/buildbucket"
+	"github.com/pulumi/travisqueue/pkg/provider/github"
+	"github.com/pulumi/travisqueue/pkg/provider/travis"
+	"github.com/pulumi/travisqueue/pkg/travisci"
 )
 
 func mustGetenv(key string) string {
@@ -17,16 +40,15 @@ func mustGetenv(key string) string {
 	if value == "" {
 		log.Fatalf("ERROR: %v is not set\n", key)
 	}
-
 	return value
 }
 
 func mustParseURL(v string) *url.URL {
-	url, err := url.Parse(v)
+	u, err := url.Parse(v)
 	if err != nil {
 		log.Fatalf("can't parse %v as URL: %v", v, err)
 	}
-	return url
+	return u
 }
 
 func mustAtoi(v string) int {
@@ -37,150 +59,421 @@ func mustAtoi(v string) int {
 	return i
 }
 
-var (
-	// https://docs.travis-ci.com/user/environment-variables/#Default-Environment-Variables
-	travisEndpoint = mustParseURL(mustGetenv("TRAVIS_ENDPOINT"))
-	travisToken    = mustGetenv("TRAVIS_TOKEN")
-
-	travisBuildID = mustAtoi(mustGetenv("TRAVIS_BUILD_ID"))
+func mustAtoi64(v string) int64 {
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Fatalf("can't convert %v to int64: %v", v, err)
+	}
+	return i
+}
 
-	travisEventType = mustGetenv("TRAVIS_EVENT_TYPE")
-	travisBranch    = mustGetenv("TRAVIS_BRANCH")
-	travisRepoSlug  = mustGetenv("TRAVIS_REPO_SLUG")
+// getenvDuration returns the value of the named environment variable parsed
+// as a time.Duration, or def if the variable is unset or empty.
+func getenvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("can't parse %v=%v as a duration: %v", key, v, err)
+	}
+	return d
+}
 
+var (
 	// Comma-separated list of branches to limit to one build.
 	// If unset or empty, limit *all* branches to one build.
-	onebuildBranches = strings.Split(os.Getenv("ONEBUILD_BRANCHES"), ",")
+	onebuildBranches = parseList(os.Getenv("ONEBUILD_BRANCHES"))
+
+	// Comma-separated list of PR numbers to limit to one build.
+	// If unset or empty, limit *all* PRs to one build.
+	onebuildPRs = parseList(os.Getenv("ONEBUILD_PRS"))
+
+	// Which event types to dedupe: "push", "pull_request", or "both".
+	onebuildMode = getenvOneOf("ONEBUILD_MODE", "push", "push", "pull_request", "both")
+
+	// Per-branch concurrency limits, e.g. "master=2,release-*=1"; see
+	// concurrencyLimit. Branches matching no pattern default to a limit of 1.
+	onebuildConcurrency = parseConcurrency(os.Getenv("ONEBUILD_CONCURRENCY"))
+
+	// Ordered glob patterns carving a branch's queue into path-scoped
+	// sub-queues, e.g. "sdk/**,cli/**,docs/**"; see pathGroup. Empty
+	// disables path scoping, so every build shares one branch-wide queue.
+	onebuildPaths = parsePaths(os.Getenv("ONEBUILD_PATHS"))
+
+	// How often to poll for cancellation to take effect, and how long to
+	// wait before giving up.
+	cancelPollInterval = getenvDuration("ONEBUILD_CANCEL_POLL_INTERVAL", 10*time.Second)
+	cancelCeiling      = getenvDuration("ONEBUILD_CANCEL_CEILING", 2*time.Hour+15*time.Minute)
 )
 
-// https://developer.travis-ci.org/resource/build#Build
-// This definition only includes fields we need.
-type Build struct {
-	ID int
+// concurrencyRule is one "pattern=limit" entry of ONEBUILD_CONCURRENCY.
+type concurrencyRule struct {
+	Pattern string
+	Limit   int
+}
+
+// parseList parses a comma-separated ONEBUILD_BRANCHES/ONEBUILD_PRS list,
+// returning nil (rather than []string{""}) when v is empty so callers can
+// treat len(list) == 0 as "no filter, match everything".
+func parseList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
 
-	Number string
-	State  string
+// parseConcurrency parses ONEBUILD_CONCURRENCY's "pattern=limit,..." syntax,
+// where pattern is a path.Match glob matched against a branch name (e.g.
+// "release-*").
+func parseConcurrency(v string) []concurrencyRule {
+	if v == "" {
+		return nil
+	}
 
-	// e.g. "2006-01-02T15:04:05Z" or nil if not started
-	StartedAt *string `json:"started_at"`
+	var rules []concurrencyRule
+	for _, entry := range strings.Split(v, ",") {
+		pattern, limitStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			log.Fatalf("invalid ONEBUILD_CONCURRENCY entry %q: want pattern=limit", entry)
+		}
+		rules = append(rules, concurrencyRule{Pattern: pattern, Limit: mustAtoi(limitStr)})
+	}
+	return rules
 }
 
-// https://developer.travis-ci.org/resource/builds#Builds
-type Builds struct {
-	Builds []Build
+// concurrencyLimit returns how many builds may run concurrently for key: the
+// limit of the first ONEBUILD_CONCURRENCY pattern matching key.Branch, or 1
+// if none matches or key is a pull request (ONEBUILD_CONCURRENCY only
+// applies to branches).
+func concurrencyLimit(key provider.QueueKey) int {
+	if key.PullRequestNumber != 0 {
+		return 1
+	}
+	for _, r := range onebuildConcurrency {
+		if ok, err := path.Match(r.Pattern, key.Branch); err == nil && ok {
+			return r.Limit
+		}
+	}
+	return 1
 }
 
-// If bodyValue is non-nil, decodes body as JSON into it.
-// Exits on error.
-func callTravisAPI(method, path string, expectStatus int, bodyValue interface{}) {
-	url := travisEndpoint.ResolveReference(mustParseURL(path))
-	req, err := http.NewRequest(method, url.String(), nil)
-	if err != nil {
-		log.Fatalf("couldn't create request to %v", url)
+// parsePaths parses ONEBUILD_PATHS's "pattern,..." syntax.
+func parsePaths(v string) []string {
+	if v == "" {
+		return nil
 	}
+	return strings.Split(v, ",")
+}
 
-	req.Header.Add("Travis-API-Version", "3")
-	req.Header.Add("Authorization", "token "+travisToken)
+// globMatch reports whether file matches pattern. It extends path.Match
+// with a trailing "/**", meaning "this directory and everything below it"
+// (path.Match's own wildcards never match "/", so it alone can't express
+// that).
+func globMatch(pattern, file string) bool {
+	if strings.HasSuffix(pattern, "/**") {
+		dir := strings.TrimSuffix(pattern, "/**")
+		return file == dir || strings.HasPrefix(file, dir+"/")
+	}
+	ok, err := path.Match(pattern, file)
+	return err == nil && ok
+}
+
+// pathGroup returns the first ONEBUILD_PATHS pattern matching any of files,
+// or "" if none does (or ONEBUILD_PATHS is unset), meaning the build falls
+// back to the branch-wide queue.
+func pathGroup(files []string) string {
+	for _, pattern := range onebuildPaths {
+		for _, f := range files {
+			if globMatch(pattern, f) {
+				return pattern
+			}
+		}
+	}
+	return ""
+}
 
-	res, err := http.DefaultClient.Do(req)
+// changedFilesOrNil returns ref's changed files via prov, or nil if prov
+// doesn't implement provider.ChangedFilesProvider, or the files otherwise
+// can't be determined. Callers should treat a nil result as "assume the
+// branch-wide queue", the same as an empty ONEBUILD_PATHS.
+func changedFilesOrNil(ctx context.Context, prov provider.Provider, ref provider.BuildRef) []string {
+	lister, ok := prov.(provider.ChangedFilesProvider)
+	if !ok {
+		return nil
+	}
+	files, err := lister.ChangedFiles(ctx, ref)
 	if err != nil {
-		log.Fatalf("request to %v failed: %v", url, err)
+		if err != provider.ErrFilesUnavailable {
+			log.Printf("finding changed files for build %v: %v\n", ref.ID, err)
+		}
+		return nil
 	}
-	defer res.Body.Close()
+	return files
+}
 
-	if res.StatusCode != expectStatus {
-		log.Fatalf("request to %v failed: %v", url, res.Status)
+// filterByGroup keeps only the builds in refs whose changed files map to
+// the same ONEBUILD_PATHS group as self (group), so that a build in one
+// path-scoped queue never counts against, or is canceled by, a build in
+// another.
+func filterByGroup(ctx context.Context, prov provider.Provider, refs []provider.BuildRef, group string) []provider.BuildRef {
+	var filtered []provider.BuildRef
+	for _, ref := range refs {
+		if pathGroup(changedFilesOrNil(ctx, prov, ref)) == group {
+			filtered = append(filtered, ref)
+		}
 	}
+	return filtered
+}
 
-	if bodyValue != nil {
-		err = json.NewDecoder(res.Body).Decode(bodyValue)
-		if err != nil {
-			log.Fatalf("can't decode response as %T: %v", bodyValue, err)
+// getenvOneOf returns the value of the named environment variable, which
+// must be def or one of others if set. Exits if it's set to anything else.
+func getenvOneOf(key, def string, others ...string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	for _, o := range append([]string{def}, others...) {
+		if v == o {
+			return v
 		}
 	}
+	log.Fatalf("%v=%v is not one of %v", key, v, append([]string{def}, others...))
+	panic("unreachable")
 }
 
-// Return the build
-// - in this repository
-// - of this branch
-// - started by a `push` event
-// - with a state in `states`, or in any state if `states` is empty
-// - that sorts first by `sortBy`, as interpreted by the Travis API.
-// Exits on error or if no matching build is found.
-// https://developer.travis-ci.com/resource/builds#find
-func firstMatchingBuild(states, sortBy string) Build {
-	vs := url.Values{}
-	vs.Add("build.event_type", "push")
-	vs.Add("build.branch", travisBranch)
-	vs.Add("sort_by", sortBy)
-	if states != "" {
-		vs.Add("build.state", states)
+// buildEvent describes the event that triggered the current build, in
+// terms package main needs to decide whether and how to dedupe it.
+type buildEvent struct {
+	// EventType is "push", "pull_request", or anything else Travis,
+	// GitHub, or Buildbucket may report; only "push" and "pull_request"
+	// are ever deduped.
+	EventType string
+
+	Branch            string
+	PullRequestNumber int
+}
+
+func (e buildEvent) queueKey() provider.QueueKey {
+	if e.EventType == "pull_request" {
+		return provider.QueueKey{PullRequestNumber: e.PullRequestNumber}
 	}
-	vs.Add("limit", "1")
+	return provider.QueueKey{Branch: e.Branch}
+}
 
-	var builds Builds
+// newProvider builds the provider.Provider selected by TRAVISQUEUE_PROVIDER
+// (default "travis") from that provider's own environment variables, and
+// returns it along with the event that triggered the current build.
+func newProvider() (provider.Provider, buildEvent) {
+	switch p := os.Getenv("TRAVISQUEUE_PROVIDER"); p {
+	case "", "travis":
+		// https://docs.travis-ci.com/user/environment-variables/#Default-Environment-Variables
+		endpoint := mustParseURL(mustGetenv("TRAVIS_ENDPOINT"))
+		token := mustGetenv("TRAVIS_TOKEN")
+		selfID := mustAtoi(mustGetenv("TRAVIS_BUILD_ID"))
+		repoSlug := mustGetenv("TRAVIS_REPO_SLUG")
+
+		client := travisci.NewClient(endpoint, token)
+		event := buildEvent{
+			EventType:         mustGetenv("TRAVIS_EVENT_TYPE"),
+			Branch:            mustGetenv("TRAVIS_BRANCH"),
+			PullRequestNumber: travisPullRequestNumber(),
+		}
 
-	path := fmt.Sprintf("/repo/%v/builds?%v", url.PathEscape(travisRepoSlug), vs.Encode())
-	callTravisAPI("GET", path, http.StatusOK, &builds)
+		prov := travis.New(client, repoSlug, selfID)
+		// Both only needed for ONEBUILD_PATHS path-scoped queues; travis.Provider
+		// falls back to branch-wide queues if GitHubToken is unset.
+		prov.GitHubToken = os.Getenv("ONEBUILD_GITHUB_TOKEN")
+		prov.SelfCommitRange = os.Getenv("TRAVIS_COMMIT_RANGE")
+		return prov, event
+
+	case "github":
+		// https://docs.github.com/en/actions/learn-github-actions/variables#default-environment-variables
+		token := mustGetenv("GITHUB_TOKEN")
+		repo := mustGetenv("GITHUB_REPOSITORY")
+		selfRunID := mustGetenv("GITHUB_RUN_ID")
+
+		event := buildEvent{
+			EventType:         mustGetenv("GITHUB_EVENT_NAME"),
+			Branch:            os.Getenv("GITHUB_REF_NAME"),
+			PullRequestNumber: githubPullRequestNumber(),
+		}
+		return github.New(token, repo, selfRunID), event
+
+	case "buildbucket":
+		host := mustGetenv("BUILDBUCKET_HOST")
+		token := mustGetenv("BUILDBUCKET_TOKEN")
+		builder := buildbucket.Builder{
+			Project: mustGetenv("BUILDBUCKET_PROJECT"),
+			Bucket:  mustGetenv("BUILDBUCKET_BUCKET"),
+			Builder: mustGetenv("BUILDBUCKET_BUILDER"),
+		}
+		selfID := mustAtoi64(mustGetenv("BUILDBUCKET_ID"))
+
+		// Buildbucket has no standard notion of "push" vs. "pull_request";
+		// travisqueue treats every build as a push build, keyed by
+		// BUILDBUCKET_BRANCH, unless BUILDBUCKET_PR says otherwise.
+		event := buildEvent{EventType: "push", Branch: os.Getenv("BUILDBUCKET_BRANCH")}
+		if pr := os.Getenv("BUILDBUCKET_PR"); pr != "" {
+			event.EventType = "pull_request"
+			event.PullRequestNumber = mustAtoi(pr)
+		}
+		return buildbucket.New(host, token, builder, selfID), event
 
-	if len(builds.Builds) == 0 {
-		// We should at least see ourselves.
-		log.Fatal("found no builds")
+	default:
+		log.Fatalf("Unknown TRAVISQUEUE_PROVIDER %q", p)
+		panic("unreachable")
 	}
+}
 
-	return builds.Builds[0]
+// travisPullRequestNumber returns the pull request number of the current
+// build from TRAVIS_PULL_REQUEST, or 0 if this isn't a pull_request build
+// (Travis sets that variable to the literal string "false" in that case).
+func travisPullRequestNumber() int {
+	v := os.Getenv("TRAVIS_PULL_REQUEST")
+	if v == "" || v == "false" {
+		return 0
+	}
+	return mustAtoi(v)
 }
 
-func earliestStartedBuild() Build {
-	return firstMatchingBuild("started", "started_at")
+// githubPullRequestNumber returns the pull request number of the current
+// build, parsed out of GITHUB_REF (e.g. "refs/pull/123/merge"), or 0 if
+// this isn't a pull_request build.
+func githubPullRequestNumber() int {
+	ref := os.Getenv("GITHUB_REF")
+	const prefix, suffix = "refs/pull/", "/merge"
+	if !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, suffix) {
+		return 0
+	}
+	return mustAtoi(strings.TrimSuffix(strings.TrimPrefix(ref, prefix), suffix))
 }
 
-func newestFinishedBuild() Build {
-	return firstMatchingBuild("passed,failed,errored", "id:desc")
+func cancelThisBuild(ctx context.Context, prov provider.Provider, self provider.BuildRef) {
+	log.Print("Cancelling this build...")
+
+	if err := prov.Cancel(ctx, self); err != nil {
+		log.Fatalf("cancelling build %v: %v", self.ID, err)
+	}
+
+	awaitCancellation(ctx, prov, self)
 }
 
-func newestBuild() Build {
-	return firstMatchingBuild("", "id:desc")
+// awaitCancellation polls the build until it reaches a terminal state
+// (canceled, passed, failed, or errored), logging the transition and how
+// long it took. It gives up after cancelCeiling, and returns promptly if
+// ctx is canceled (e.g. by a SIGTERM from the CI runner itself).
+func awaitCancellation(ctx context.Context, prov provider.Provider, ref provider.BuildRef) {
+	start := time.Now()
+	ticker := time.NewTicker(cancelPollInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(cancelCeiling)
+	defer deadline.Stop()
+
+	for {
+		b, err := prov.Get(ctx, ref)
+		if err != nil {
+			log.Printf("polling build %v: %v", ref.ID, err)
+		} else if isTerminal(b.State) {
+			if b.State == provider.StateCanceled {
+				log.Printf("BuildCanceled: build %v canceled after %v\n", ref.ID, time.Since(start).Round(time.Second))
+			} else {
+				log.Printf("Build %v reached terminal state %v after %v\n", ref.ID, b.State, time.Since(start).Round(time.Second))
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped waiting for build %v to cancel: %v\n", ref.ID, ctx.Err())
+			return
+		case <-deadline.C:
+			log.Fatalf("Build %v did not reach a terminal state within %v\n", ref.ID, cancelCeiling)
+		case <-ticker.C:
+		}
+	}
 }
 
-func cancelThisBuild() {
-	log.Print("Cancelling this build...")
+func isTerminal(state string) bool {
+	switch state {
+	case provider.StateCanceled, provider.StatePassed, provider.StateFailed, provider.StateErrored:
+		return true
+	default:
+		return false
+	}
+}
 
-	path := fmt.Sprintf("/build/%v/cancel", travisBuildID)
-	callTravisAPI("POST", path, http.StatusAccepted, nil)
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
 
-	// Wait for the build to be cancelled. Travis' build timeout is 2 hours.
-	time.Sleep(3 * time.Hour)
+func containsInt(list []string, v int) bool {
+	return contains(list, strconv.Itoa(v))
 }
 
-func restartBuild(id int) {
-	path := fmt.Sprintf("/build/%v/restart", id)
-	callTravisAPI("POST", path, http.StatusAccepted, nil)
+// indexOf returns the position of the build with the given id within refs,
+// or len(refs) if it isn't present (treated as running behind every known
+// build, so it's subject to the concurrency limit like any other latecomer).
+func indexOf(refs []provider.BuildRef, id string) int {
+	for i, r := range refs {
+		if r.ID == id {
+			return i
+		}
+	}
+	return len(refs)
+}
+
+// idGreater reports whether a is a numerically greater BuildRef ID than b;
+// see the BuildRef doc comment for why this is a valid recency comparison
+// across providers.
+func idGreater(a, b string) bool {
+	ai, err := strconv.ParseInt(a, 10, 64)
+	if err != nil {
+		log.Fatalf("build id %q is not an integer: %v", a, err)
+	}
+	bi, err := strconv.ParseInt(b, 10, 64)
+	if err != nil {
+		log.Fatalf("build id %q is not an integer: %v", b, err)
+	}
+	return ai > bi
 }
 
 func main() {
-	// Ignore non-push builds.
-	if travisEventType != "push" {
-		log.Print("Not a push build. Exiting.")
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	prov, event := newProvider()
+
+	// Ignore event types ONEBUILD_MODE isn't configured to dedupe.
+	if event.EventType != "push" && event.EventType != "pull_request" {
+		log.Printf("Not a push or pull_request build. Exiting.")
+		os.Exit(0)
+	}
+	if onebuildMode != "both" && event.EventType != onebuildMode {
+		log.Printf("ONEBUILD_MODE=%v; ignoring %v build. Exiting.", onebuildMode, event.EventType)
 		os.Exit(0)
 	}
 
-	// If ONEBUILD_BRANCHES is set, ignore branches not in that list.
-	if len(onebuildBranches) > 0 {
-		found := false
-		for _, b := range onebuildBranches {
-			if b == travisBranch {
-				found := true
-				break
-			}
-		}
-
-		if !found {
-			log.Printf("Branch %v not in %v. Exiting.", travisBranch, onebuildBranches)
+	// If ONEBUILD_BRANCHES/ONEBUILD_PRS is set, ignore branches/PRs not in
+	// that list.
+	if event.EventType == "pull_request" {
+		if len(onebuildPRs) > 0 && !containsInt(onebuildPRs, event.PullRequestNumber) {
+			log.Printf("PR %v not in %v. Exiting.", event.PullRequestNumber, onebuildPRs)
 			os.Exit(0)
 		}
+	} else if len(onebuildBranches) > 0 && !contains(onebuildBranches, event.Branch) {
+		log.Printf("Branch %v not in %v. Exiting.", event.Branch, onebuildBranches)
+		os.Exit(0)
 	}
 
+	key := event.queueKey()
+
 	command := ""
 	if len(os.Args) > 1 {
 		command = os.Args[1]
@@ -188,31 +481,97 @@ func main() {
 
 	switch command {
 	case "start":
-		// Check we're the running build with the earliest start time.
-		earliest := earliestStartedBuild()
-		if earliest.ID != travisBuildID {
-			log.Printf("Found an older build running: %v (%v) started at %v\n", earliest.Number, earliest.ID, *earliest.StartedAt)
-			cancelThisBuild()
-		}
-
-		// Check there are no newer, finished builds.
-		finished := newestFinishedBuild()
-		if finished.ID > travisBuildID {
-			log.Printf("Found a newer finished build: %v (%v), state %v\n", finished.Number, finished.ID, finished.State)
-			cancelThisBuild()
+		self := prov.Self()
+		if cancel, reason := startDecision(ctx, prov, key, self); cancel {
+			log.Println(reason)
+			cancelThisBuild(ctx, prov, self)
 		}
-
 		// Okay to proceed.
 
 	case "finish":
-		// Restart the newest queued build if it is cancelled.
-		newest := newestBuild()
-		if newest.State == "canceled" /* [sic] */ {
-			log.Printf("Restarting cancelled build %v (%v)\n", newest.Number, newest.ID)
-			restartBuild(newest.ID)
+		restart, ref, reason := finishDecision(ctx, prov, key)
+		if !restart {
+			if reason != "" {
+				log.Println(reason)
+			}
+			break
+		}
+
+		log.Printf("Restarting cancelled build %v (%v)\n", ref.Number, ref.ID)
+		if err := prov.Restart(ctx, ref); err != nil {
+			log.Fatalf("restarting build %v: %v", ref.ID, err)
 		}
 
 	default:
 		log.Fatalf("Usage: %v {start|finish}\n", os.Args[0])
 	}
 }
+
+// startDecision reports whether self should cancel itself: either because
+// it's running behind concurrencyLimit(key) other builds in the same queue
+// (and, if ONEBUILD_PATHS is set, the same path group), or because a newer
+// build in that queue and group has already finished. reason explains which,
+// for logging, and is "" when cancel is false.
+func startDecision(ctx context.Context, prov provider.Provider, key provider.QueueKey, self provider.BuildRef) (cancel bool, reason string) {
+	limit := concurrencyLimit(key)
+
+	var selfGroup string
+	if len(onebuildPaths) > 0 {
+		selfGroup = pathGroup(changedFilesOrNil(ctx, prov, self))
+	}
+
+	// Check we're within the first `limit` running builds, earliest
+	// start time first, so the oldest builds in the queue always win.
+	running, err := prov.Running(ctx, key)
+	if err != nil && err != provider.ErrNoBuilds {
+		log.Fatalf("finding running builds: %v", err)
+	}
+	if len(onebuildPaths) > 0 {
+		running = filterByGroup(ctx, prov, running, selfGroup)
+	}
+	if position := indexOf(running, self.ID); position >= limit {
+		return true, fmt.Sprintf("Found %v build(s) running ahead of this one (limit %v)", position, limit)
+	}
+
+	// Check there are no newer, finished builds in the same
+	// ONEBUILD_PATHS group as self; a finished build in another group
+	// (e.g. docs-only) must never cancel an in-flight build in ours.
+	finished, err := prov.NewestFinished(ctx, key)
+	if err != nil && err != provider.ErrNoBuilds {
+		log.Fatalf("finding newest finished build: %v", err)
+	}
+	inGroup := err == nil
+	if inGroup && len(onebuildPaths) > 0 {
+		inGroup = pathGroup(changedFilesOrNil(ctx, prov, finished)) == selfGroup
+	}
+	if inGroup && idGreater(finished.ID, self.ID) {
+		return true, fmt.Sprintf("Found a newer finished build: %v (%v), state %v", finished.Number, finished.ID, finished.State)
+	}
+
+	return false, ""
+}
+
+// finishDecision reports whether the newest build in key should be
+// restarted: it must have finished canceled (to make way for another build,
+// per startDecision), and restarting it must not exceed concurrencyLimit.
+// reason explains why not, for logging, and is "" when newest was never
+// canceled in the first place.
+func finishDecision(ctx context.Context, prov provider.Provider, key provider.QueueKey) (restart bool, ref provider.BuildRef, reason string) {
+	newest, err := prov.Newest(ctx, key)
+	if err != nil {
+		log.Fatalf("finding newest build: %v", err)
+	}
+	if newest.State != provider.StateCanceled {
+		return false, provider.BuildRef{}, ""
+	}
+
+	running, err := prov.Running(ctx, key)
+	if err != nil && err != provider.ErrNoBuilds {
+		log.Fatalf("finding running builds: %v", err)
+	}
+	if limit := concurrencyLimit(key); len(running) >= limit {
+		return false, provider.BuildRef{}, fmt.Sprintf("%v build(s) already running (limit %v); leaving %v (%v) canceled", len(running), limit, newest.Number, newest.ID)
+	}
+
+	return true, newest, ""
+}
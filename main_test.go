@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pulumi/travisqueue/pkg/provider"
+)
+
+func TestContains(t *testing.T) {
+	if !contains([]string{"a", "b"}, "b") {
+		t.Error("want true for present element")
+	}
+	if contains([]string{"a", "b"}, "c") {
+		t.Error("want false for absent element")
+	}
+	if contains(nil, "a") {
+		t.Error("want false for nil list")
+	}
+}
+
+func TestContainsInt(t *testing.T) {
+	if !containsInt([]string{"1", "42"}, 42) {
+		t.Error("want true for present element")
+	}
+	if containsInt([]string{"1", "42"}, 7) {
+		t.Error("want false for absent element")
+	}
+}
+
+func TestParseList(t *testing.T) {
+	if got := parseList(""); got != nil {
+		t.Errorf("parseList(%q) = %#v, want nil", "", got)
+	}
+	got := parseList("a,b,c")
+	want := []string{"a", "b", "c"}
+	if len(got) != len(want) {
+		t.Fatalf("parseList(%q) = %#v, want %#v", "a,b,c", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseList(%q)[%d] = %q, want %q", "a,b,c", i, got[i], want[i])
+		}
+	}
+}
+
+func TestIndexOf(t *testing.T) {
+	refs := []provider.BuildRef{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	if got := indexOf(refs, "2"); got != 1 {
+		t.Errorf("indexOf = %d, want 1", got)
+	}
+	if got := indexOf(refs, "missing"); got != len(refs) {
+		t.Errorf("indexOf(missing) = %d, want %d", got, len(refs))
+	}
+}
+
+func TestIdGreater(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"10", "2", true},
+		{"2", "10", false},
+		{"5", "5", false},
+	}
+	for _, c := range cases {
+		if got := idGreater(c.a, c.b); got != c.want {
+			t.Errorf("idGreater(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, file string
+		want          bool
+	}{
+		{"sdk/**", "sdk/go/client.go", true},
+		{"sdk/**", "sdk", true},
+		{"sdk/**", "sdk-extra/client.go", false},
+		{"docs/*.md", "docs/README.md", true},
+		{"docs/*.md", "docs/sub/README.md", false},
+	}
+	for _, c := range cases {
+		if got := globMatch(c.pattern, c.file); got != c.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", c.pattern, c.file, got, c.want)
+		}
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	old := onebuildConcurrency
+	defer func() { onebuildConcurrency = old }()
+	onebuildConcurrency = parseConcurrency("master=2,release-*=1")
+
+	if got := concurrencyLimit(provider.QueueKey{Branch: "master"}); got != 2 {
+		t.Errorf("concurrencyLimit(master) = %d, want 2", got)
+	}
+	if got := concurrencyLimit(provider.QueueKey{Branch: "release-1.0"}); got != 1 {
+		t.Errorf("concurrencyLimit(release-1.0) = %d, want 1", got)
+	}
+	if got := concurrencyLimit(provider.QueueKey{Branch: "feature-x"}); got != 1 {
+		t.Errorf("concurrencyLimit(feature-x) = %d, want 1 (no pattern matches)", got)
+	}
+	if got := concurrencyLimit(provider.QueueKey{PullRequestNumber: 7}); got != 1 {
+		t.Errorf("concurrencyLimit(pull request) = %d, want 1 (ONEBUILD_CONCURRENCY only applies to branches)", got)
+	}
+}
+
+// fakeProvider is a minimal in-memory provider.Provider for exercising
+// startDecision/finishDecision without a real CI backend.
+type fakeProvider struct {
+	self           provider.BuildRef
+	running        []provider.BuildRef
+	runningErr     error
+	newestFinished provider.BuildRef
+	newestFinErr   error
+	newest         provider.BuildRef
+	newestErr      error
+	files          map[string][]string
+}
+
+func (f *fakeProvider) Self() provider.BuildRef { return f.self }
+
+func (f *fakeProvider) Running(ctx context.Context, key provider.QueueKey) ([]provider.BuildRef, error) {
+	return f.running, f.runningErr
+}
+
+func (f *fakeProvider) NewestFinished(ctx context.Context, key provider.QueueKey) (provider.BuildRef, error) {
+	return f.newestFinished, f.newestFinErr
+}
+
+func (f *fakeProvider) Newest(ctx context.Context, key provider.QueueKey) (provider.BuildRef, error) {
+	return f.newest, f.newestErr
+}
+
+func (f *fakeProvider) Get(ctx context.Context, ref provider.BuildRef) (provider.BuildRef, error) {
+	return ref, nil
+}
+
+func (f *fakeProvider) Cancel(ctx context.Context, ref provider.BuildRef) error { return nil }
+
+func (f *fakeProvider) Restart(ctx context.Context, ref provider.BuildRef) error { return nil }
+
+func (f *fakeProvider) ChangedFiles(ctx context.Context, ref provider.BuildRef) ([]string, error) {
+	return f.files[ref.ID], nil
+}
+
+func TestStartDecisionWithinLimit(t *testing.T) {
+	self := provider.BuildRef{ID: "5"}
+	prov := &fakeProvider{
+		self:           self,
+		running:        []provider.BuildRef{{ID: "5"}},
+		newestFinErr:   provider.ErrNoBuilds,
+		newestFinished: provider.BuildRef{},
+	}
+	cancel, reason := startDecision(context.Background(), prov, provider.QueueKey{Branch: "master"}, self)
+	if cancel {
+		t.Errorf("startDecision cancel = true (%q), want false", reason)
+	}
+}
+
+func TestStartDecisionRunningAhead(t *testing.T) {
+	self := provider.BuildRef{ID: "5"}
+	prov := &fakeProvider{
+		self:         self,
+		running:      []provider.BuildRef{{ID: "4"}, {ID: "5"}},
+		newestFinErr: provider.ErrNoBuilds,
+	}
+	cancel, reason := startDecision(context.Background(), prov, provider.QueueKey{Branch: "master"}, self)
+	if !cancel {
+		t.Fatal("startDecision cancel = false, want true (a build is already running ahead of it)")
+	}
+	if reason == "" {
+		t.Error("startDecision reason is empty, want an explanation")
+	}
+}
+
+func TestStartDecisionNewerFinished(t *testing.T) {
+	self := provider.BuildRef{ID: "5"}
+	prov := &fakeProvider{
+		self:           self,
+		runningErr:     provider.ErrNoBuilds,
+		newestFinished: provider.BuildRef{ID: "9", Number: "9", State: provider.StatePassed},
+	}
+	cancel, reason := startDecision(context.Background(), prov, provider.QueueKey{Branch: "master"}, self)
+	if !cancel {
+		t.Fatal("startDecision cancel = false, want true (a newer build already finished)")
+	}
+	if reason == "" {
+		t.Error("startDecision reason is empty, want an explanation")
+	}
+}
+
+func TestStartDecisionPathScopedSkipsOtherGroup(t *testing.T) {
+	old := onebuildPaths
+	defer func() { onebuildPaths = old }()
+	onebuildPaths = []string{"sdk/**", "docs/**"}
+
+	self := provider.BuildRef{ID: "5"}
+	prov := &fakeProvider{
+		self:           self,
+		runningErr:     provider.ErrNoBuilds,
+		newestFinished: provider.BuildRef{ID: "9", Number: "9", State: provider.StatePassed},
+		files: map[string][]string{
+			"5": {"sdk/go/client.go"},
+			"9": {"docs/README.md"},
+		},
+	}
+	cancel, _ := startDecision(context.Background(), prov, provider.QueueKey{Branch: "master"}, self)
+	if cancel {
+		t.Error("startDecision cancel = true, want false (the finished build is in an unrelated path group)")
+	}
+}
+
+func TestFinishDecisionRestarts(t *testing.T) {
+	prov := &fakeProvider{
+		newest:     provider.BuildRef{ID: "9", Number: "9", State: provider.StateCanceled},
+		runningErr: provider.ErrNoBuilds,
+	}
+	restart, ref, reason := finishDecision(context.Background(), prov, provider.QueueKey{Branch: "master"})
+	if !restart {
+		t.Fatalf("finishDecision restart = false (%q), want true", reason)
+	}
+	if ref.ID != "9" {
+		t.Errorf("finishDecision ref.ID = %q, want %q", ref.ID, "9")
+	}
+}
+
+func TestFinishDecisionNotCanceled(t *testing.T) {
+	prov := &fakeProvider{
+		newest: provider.BuildRef{ID: "9", Number: "9", State: provider.StatePassed},
+	}
+	restart, _, reason := finishDecision(context.Background(), prov, provider.QueueKey{Branch: "master"})
+	if restart {
+		t.Error("finishDecision restart = true, want false (newest build wasn't canceled)")
+	}
+	if reason != "" {
+		t.Errorf("finishDecision reason = %q, want empty", reason)
+	}
+}
+
+func TestFinishDecisionAtLimit(t *testing.T) {
+	prov := &fakeProvider{
+		newest:  provider.BuildRef{ID: "9", Number: "9", State: provider.StateCanceled},
+		running: []provider.BuildRef{{ID: "10"}},
+	}
+	restart, _, reason := finishDecision(context.Background(), prov, provider.QueueKey{Branch: "master"})
+	if restart {
+		t.Error("finishDecision restart = true, want false (already at the concurrency limit)")
+	}
+	if reason == "" {
+		t.Error("finishDecision reason is empty, want an explanation")
+	}
+}
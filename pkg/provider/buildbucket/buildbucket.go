@@ -0,0 +1,299 @@
+// Package buildbucket implements provider.Provider for LUCI Buildbucket
+// (https://source.chromium.org/chromium/infra/infra/+/main:go/src/go.chromium.org/luci/buildbucket/proto/builds_service.proto).
+//
+// It speaks Buildbucket's pRPC JSON wire protocol
+// (https://pkg.go.dev/go.chromium.org/luci/grpc/prpc#hdr-Protocol) directly
+// over net/http rather than depending on the generated bbpb gRPC client, to
+// keep travisqueue free of the LUCI dependency tree for what is otherwise a
+// handful of RPCs.
+//
+// Buildbucket build IDs decrease as builds are created, the opposite of
+// Travis and GitHub Actions. To let callers compare BuildRef.ID across
+// providers with a plain numeric "greater is newer" check, this package
+// inverts the raw ID (math.MaxInt64 - id) before returning it, and inverts
+// back before issuing requests.
+package buildbucket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/travisqueue/pkg/provider"
+)
+
+// xssiPrefix is prepended to every pRPC JSON response body as a defense
+// against JSON hijacking; it must be stripped before decoding.
+const xssiPrefix = ")]}'\n"
+
+// Builder identifies which builder's builds to search.
+type Builder struct {
+	Project string
+	Bucket  string
+	Builder string
+}
+
+// Provider adapts a Buildbucket host to provider.Provider for a single
+// builder and build.
+type Provider struct {
+	// Host is the Buildbucket pRPC host, e.g. "cr-buildbucket.appspot.com".
+	Host string
+
+	// Token is an OAuth2 access token, sent as "Authorization: Bearer <Token>".
+	Token string
+
+	Builder Builder
+
+	// SelfID is the raw (non-inverted) Buildbucket build ID travisqueue is
+	// running inside of.
+	SelfID int64
+
+	HTTPClient *http.Client
+}
+
+// New returns a Provider for builder on host.
+func New(host, token string, builder Builder, selfID int64) *Provider {
+	return &Provider{Host: host, Token: token, Builder: builder, SelfID: selfID, HTTPClient: http.DefaultClient}
+}
+
+func (p *Provider) httpClient() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (p *Provider) Self() provider.BuildRef {
+	return provider.BuildRef{ID: invertID(p.SelfID)}
+}
+
+type build struct {
+	ID        string `json:"id"`
+	Number    int32  `json:"number"`
+	Status    string `json:"status"`
+	SummaryMD string `json:"summaryMarkdown,omitempty"`
+}
+
+type searchBuildsResponse struct {
+	Builds []build `json:"builds"`
+}
+
+// Running returns the STARTED builds in key. Buildbucket's SearchBuilds
+// returns builds newest-created-first, so the response is reversed to put
+// the earliest-started build first.
+func (p *Provider) Running(ctx context.Context, key provider.QueueKey) ([]provider.BuildRef, error) {
+	builds, err := p.searchBuilds(ctx, key, "STARTED")
+	if err != nil {
+		return nil, err
+	}
+	if len(builds) == 0 {
+		return nil, provider.ErrNoBuilds
+	}
+
+	refs := make([]provider.BuildRef, len(builds))
+	for i, b := range builds {
+		ref, err := toBuildRef(b)
+		if err != nil {
+			return nil, err
+		}
+		refs[len(builds)-1-i] = ref
+	}
+	return refs, nil
+}
+
+// NewestFinished returns the most recently created build in key that has
+// reached SUCCESS, FAILURE, or INFRA_FAILURE, skipping any STARTED,
+// SCHEDULED, or CANCELED builds in between. searchBuilds with no status
+// filter returns builds newest-created-first, so the first one that
+// matches here is the truly newest finished build, regardless of which of
+// the three terminal statuses it landed in.
+func (p *Provider) NewestFinished(ctx context.Context, key provider.QueueKey) (provider.BuildRef, error) {
+	builds, err := p.searchBuilds(ctx, key, "")
+	if err != nil {
+		return provider.BuildRef{}, err
+	}
+	for _, b := range builds {
+		switch b.Status {
+		case "SUCCESS", "FAILURE", "INFRA_FAILURE":
+			return toBuildRef(b)
+		}
+	}
+	return provider.BuildRef{}, provider.ErrNoBuilds
+}
+
+func (p *Provider) Newest(ctx context.Context, key provider.QueueKey) (provider.BuildRef, error) {
+	builds, err := p.searchBuilds(ctx, key, "")
+	if err != nil {
+		return provider.BuildRef{}, err
+	}
+	if len(builds) == 0 {
+		return provider.BuildRef{}, provider.ErrNoBuilds
+	}
+	return toBuildRef(builds[0])
+}
+
+// searchBuilds calls Builds.SearchBuilds, scoped to p.Builder and a
+// "branch:<branch>" or "pr:<number>" tag (the convention travisqueue's
+// Buildbucket configs use to record the triggering branch or pull
+// request), optionally filtered by status.
+func (p *Provider) searchBuilds(ctx context.Context, key provider.QueueKey, status string) ([]build, error) {
+	tag := map[string]string{"key": "branch", "value": key.Branch}
+	if key.PullRequestNumber != 0 {
+		tag = map[string]string{"key": "pr", "value": strconv.Itoa(key.PullRequestNumber)}
+	}
+
+	predicate := map[string]interface{}{
+		"builder": map[string]string{
+			"project": p.Builder.Project,
+			"bucket":  p.Builder.Bucket,
+			"builder": p.Builder.Builder,
+		},
+		"tags": []map[string]string{tag},
+	}
+	if status != "" {
+		predicate["status"] = status
+	}
+
+	req := map[string]interface{}{
+		"predicate": predicate,
+		"fields":    "builds.*.id,builds.*.number,builds.*.status",
+		"pageSize":  100,
+	}
+
+	var resp searchBuildsResponse
+	if err := p.call(ctx, "SearchBuilds", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Builds, nil
+}
+
+func (p *Provider) Get(ctx context.Context, ref provider.BuildRef) (provider.BuildRef, error) {
+	rawID, err := revertID(ref.ID)
+	if err != nil {
+		return provider.BuildRef{}, err
+	}
+
+	req := map[string]interface{}{
+		"id":     strconv.FormatInt(rawID, 10),
+		"fields": "id,number,status",
+	}
+	var b build
+	if err := p.call(ctx, "GetBuild", req, &b); err != nil {
+		return provider.BuildRef{}, err
+	}
+	return toBuildRef(b)
+}
+
+func (p *Provider) Cancel(ctx context.Context, ref provider.BuildRef) error {
+	rawID, err := revertID(ref.ID)
+	if err != nil {
+		return err
+	}
+	req := map[string]interface{}{
+		"id":              strconv.FormatInt(rawID, 10),
+		"summaryMarkdown": "canceled by travisqueue",
+	}
+	return p.call(ctx, "CancelBuild", req, nil)
+}
+
+// Restart schedules a new build using ref as a template, LUCI Buildbucket's
+// equivalent of Travis's "restart".
+func (p *Provider) Restart(ctx context.Context, ref provider.BuildRef) error {
+	rawID, err := revertID(ref.ID)
+	if err != nil {
+		return err
+	}
+	req := map[string]interface{}{
+		"templateBuildId": strconv.FormatInt(rawID, 10),
+	}
+	return p.call(ctx, "ScheduleBuild", req, nil)
+}
+
+// call issues a single pRPC JSON request to the Buildbucket "Builds"
+// service, decoding the response into respValue if it is non-nil.
+func (p *Provider) call(ctx context.Context, method string, reqValue, respValue interface{}) error {
+	body, err := json.Marshal(reqValue)
+	if err != nil {
+		return fmt.Errorf("buildbucket: encoding %v request: %w", method, err)
+	}
+
+	url := fmt.Sprintf("https://%v/prpc/buildbucket.v2.Builds/%v", p.Host, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("buildbucket: creating %v request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+
+	res, err := p.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("buildbucket: %v: %w", method, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("buildbucket: %v: %v", method, res.Status)
+	}
+
+	if respValue == nil {
+		return nil
+	}
+
+	// Strip the XSSI prefix before decoding.
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(res.Body); err != nil {
+		return fmt.Errorf("buildbucket: reading %v response: %w", method, err)
+	}
+	trimmed := strings.TrimPrefix(buf.String(), xssiPrefix)
+	if err := json.NewDecoder(strings.NewReader(trimmed)).Decode(respValue); err != nil {
+		return fmt.Errorf("buildbucket: decoding %v response: %w", method, err)
+	}
+	return nil
+}
+
+// toBuildRef maps a Buildbucket status onto the provider-independent states
+// in package provider.
+func toBuildRef(b build) (provider.BuildRef, error) {
+	rawID, err := strconv.ParseInt(b.ID, 10, 64)
+	if err != nil {
+		return provider.BuildRef{}, fmt.Errorf("buildbucket: build id %q is not an integer: %w", b.ID, err)
+	}
+
+	var state string
+	switch b.Status {
+	case "SCHEDULED", "STARTED":
+		state = provider.StateStarted
+	case "SUCCESS":
+		state = provider.StatePassed
+	case "FAILURE":
+		state = provider.StateFailed
+	case "CANCELED":
+		state = provider.StateCanceled
+	default:
+		state = provider.StateErrored
+	}
+
+	return provider.BuildRef{
+		ID:     invertID(rawID),
+		Number: strconv.Itoa(int(b.Number)),
+		State:  state,
+	}, nil
+}
+
+func invertID(id int64) string {
+	return strconv.FormatInt(math.MaxInt64-id, 10)
+}
+
+func revertID(inverted string) (int64, error) {
+	v, err := strconv.ParseInt(inverted, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("buildbucket: id %q is not an integer: %w", inverted, err)
+	}
+	return math.MaxInt64 - v, nil
+}
@@ -0,0 +1,104 @@
+package buildbucket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pulumi/travisqueue/pkg/provider"
+)
+
+// fakeServer serves SearchBuilds responses, the raw builds newest-first as
+// the real pRPC service would, regardless of any status predicate in the
+// request (so tests can assert NewestFinished itself does the filtering).
+func fakeServer(t *testing.T, builds []build) *httptest.Server {
+	t.Helper()
+	// call() always dials https://, so the fake server must speak TLS too.
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prpc/buildbucket.v2.Builds/SearchBuilds" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, xssiPrefix)
+		if err := json.NewEncoder(w).Encode(searchBuildsResponse{Builds: builds}); err != nil {
+			t.Fatalf("encoding fake response: %v", err)
+		}
+	}))
+}
+
+func testProvider(t *testing.T, builds []build) *Provider {
+	t.Helper()
+	srv := fakeServer(t, builds)
+	t.Cleanup(srv.Close)
+	return &Provider{
+		Host:       srv.Listener.Addr().String(),
+		Token:      "test-token",
+		Builder:    Builder{Project: "p", Bucket: "b", Builder: "c"},
+		HTTPClient: srv.Client(),
+	}
+}
+
+// TestNewestFinishedSkipsOlderSuccessForNewerFailure guards against
+// returning the newest build of the first status checked rather than the
+// truly newest terminal build: an older SUCCESS must not shadow a newer
+// FAILURE.
+func TestNewestFinishedSkipsOlderSuccessForNewerFailure(t *testing.T) {
+	p := testProvider(t, []build{
+		{ID: "3", Number: 3, Status: "STARTED"},
+		{ID: "2", Number: 2, Status: "FAILURE"},
+		{ID: "1", Number: 1, Status: "SUCCESS"},
+	})
+
+	got, err := p.NewestFinished(context.Background(), provider.QueueKey{Branch: "master"})
+	if err != nil {
+		t.Fatalf("NewestFinished: %v", err)
+	}
+	if got.State != provider.StateFailed {
+		t.Errorf("NewestFinished state = %v, want %v (the newer FAILURE build, not the older SUCCESS)", got.State, provider.StateFailed)
+	}
+	want, _ := toBuildRef(build{ID: "2", Number: 2, Status: "FAILURE"})
+	if got.ID != want.ID {
+		t.Errorf("NewestFinished ID = %v, want %v", got.ID, want.ID)
+	}
+}
+
+func TestNewestFinishedSkipsCanceledAndRunning(t *testing.T) {
+	p := testProvider(t, []build{
+		{ID: "3", Number: 3, Status: "STARTED"},
+		{ID: "2", Number: 2, Status: "CANCELED"},
+		{ID: "1", Number: 1, Status: "INFRA_FAILURE"},
+	})
+
+	got, err := p.NewestFinished(context.Background(), provider.QueueKey{Branch: "master"})
+	if err != nil {
+		t.Fatalf("NewestFinished: %v", err)
+	}
+	if got.State != provider.StateErrored {
+		t.Errorf("NewestFinished state = %v, want %v", got.State, provider.StateErrored)
+	}
+}
+
+func TestNewestFinishedNoneFinished(t *testing.T) {
+	p := testProvider(t, []build{
+		{ID: "2", Number: 2, Status: "STARTED"},
+		{ID: "1", Number: 1, Status: "CANCELED"},
+	})
+
+	_, err := p.NewestFinished(context.Background(), provider.QueueKey{Branch: "master"})
+	if err != provider.ErrNoBuilds {
+		t.Errorf("NewestFinished error = %v, want provider.ErrNoBuilds", err)
+	}
+}
+
+func TestNewestFinishedNoBuilds(t *testing.T) {
+	p := testProvider(t, nil)
+
+	_, err := p.NewestFinished(context.Background(), provider.QueueKey{Branch: "master"})
+	if err != provider.ErrNoBuilds {
+		t.Errorf("NewestFinished error = %v, want provider.ErrNoBuilds", err)
+	}
+}
@@ -0,0 +1,295 @@
+// Package github implements provider.Provider against the GitHub Actions
+// workflow-runs REST API
+// (https://docs.github.com/en/rest/actions/workflow-runs), so travisqueue
+// can dedupe builds on repos that run on GitHub Actions instead of Travis.
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/pulumi/travisqueue/pkg/provider"
+)
+
+const defaultAPIEndpoint = "https://api.github.com"
+
+// Provider adapts the GitHub Actions REST API to provider.Provider for a
+// single repository and workflow run.
+type Provider struct {
+	// Endpoint is the GitHub API base URL. Defaults to
+	// https://api.github.com if empty (GitHub Enterprise Server users
+	// should set this to their instance's API URL).
+	Endpoint string
+
+	// Token is a GitHub token with `actions:read` and `actions:write`,
+	// sent as an "Authorization: Bearer <Token>" header.
+	Token string
+
+	// Repo is "owner/name".
+	Repo string
+
+	// SelfRunID is the workflow run travisqueue is running inside of
+	// (GITHUB_RUN_ID).
+	SelfRunID string
+
+	HTTPClient *http.Client
+
+	filesCache map[string][]string
+}
+
+// New returns a Provider for repo (in "owner/name" form), authenticating
+// with token.
+func New(token, repo, selfRunID string) *Provider {
+	return &Provider{
+		Endpoint:   defaultAPIEndpoint,
+		Token:      token,
+		Repo:       repo,
+		SelfRunID:  selfRunID,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+func (p *Provider) endpoint() string {
+	if p.Endpoint != "" {
+		return p.Endpoint
+	}
+	return defaultAPIEndpoint
+}
+
+func (p *Provider) httpClient() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (p *Provider) Self() provider.BuildRef {
+	return provider.BuildRef{ID: p.SelfRunID}
+}
+
+// workflowRun is the subset of
+// https://docs.github.com/en/rest/actions/workflow-runs#list-workflow-runs-for-a-repository
+// travisqueue needs.
+type workflowRun struct {
+	ID           int64                `json:"id"`
+	RunNumber    int                  `json:"run_number"`
+	Status       string               `json:"status"`     // "queued", "in_progress", "completed"
+	Conclusion   string               `json:"conclusion"` // set once Status == "completed"
+	HeadSHA      string               `json:"head_sha"`
+	PullRequests []workflowRunPullReq `json:"pull_requests"`
+}
+
+type workflowRunPullReq struct {
+	Number int `json:"number"`
+}
+
+type workflowRunsResponse struct {
+	WorkflowRuns []workflowRun `json:"workflow_runs"`
+}
+
+func (p *Provider) Running(ctx context.Context, key provider.QueueKey) ([]provider.BuildRef, error) {
+	runs, err := p.listRuns(ctx, key, "in_progress")
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, provider.ErrNoBuilds
+	}
+	// Runs are returned newest-first; reverse to earliest-first.
+	refs := make([]provider.BuildRef, len(runs))
+	for i, r := range runs {
+		refs[len(runs)-1-i] = toBuildRef(r)
+	}
+	return refs, nil
+}
+
+func (p *Provider) NewestFinished(ctx context.Context, key provider.QueueKey) (provider.BuildRef, error) {
+	runs, err := p.listRuns(ctx, key, "completed")
+	if err != nil {
+		return provider.BuildRef{}, err
+	}
+	for _, r := range runs {
+		if r.Conclusion == "cancelled" {
+			continue
+		}
+		return toBuildRef(r), nil
+	}
+	return provider.BuildRef{}, provider.ErrNoBuilds
+}
+
+func (p *Provider) Newest(ctx context.Context, key provider.QueueKey) (provider.BuildRef, error) {
+	runs, err := p.listRuns(ctx, key, "")
+	if err != nil {
+		return provider.BuildRef{}, err
+	}
+	if len(runs) == 0 {
+		return provider.BuildRef{}, provider.ErrNoBuilds
+	}
+	return toBuildRef(runs[0]), nil
+}
+
+// listRuns returns runs matching key, newest first, optionally filtered by
+// status ("queued", "in_progress", or "completed"; "" for any).
+//
+// The workflow-runs API can filter by "event" but not by pull request
+// number directly, so pull_request queue keys are filtered client-side on
+// the returned page.
+func (p *Provider) listRuns(ctx context.Context, key provider.QueueKey, status string) ([]workflowRun, error) {
+	vs := url.Values{}
+	vs.Add("per_page", "100")
+	if status != "" {
+		vs.Add("status", status)
+	}
+	if key.PullRequestNumber != 0 {
+		vs.Add("event", "pull_request")
+	} else {
+		vs.Add("branch", key.Branch)
+		vs.Add("event", "push")
+	}
+
+	path := fmt.Sprintf("/repos/%v/actions/runs?%v", p.Repo, vs.Encode())
+
+	var resp workflowRunsResponse
+	if err := p.do(ctx, http.MethodGet, path, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+
+	if key.PullRequestNumber == 0 {
+		return resp.WorkflowRuns, nil
+	}
+
+	var filtered []workflowRun
+	for _, r := range resp.WorkflowRuns {
+		for _, pr := range r.PullRequests {
+			if pr.Number == key.PullRequestNumber {
+				filtered = append(filtered, r)
+				break
+			}
+		}
+	}
+	return filtered, nil
+}
+
+func (p *Provider) Get(ctx context.Context, ref provider.BuildRef) (provider.BuildRef, error) {
+	path := fmt.Sprintf("/repos/%v/actions/runs/%v", p.Repo, ref.ID)
+	var run workflowRun
+	if err := p.do(ctx, http.MethodGet, path, http.StatusOK, &run); err != nil {
+		return provider.BuildRef{}, err
+	}
+	return toBuildRef(run), nil
+}
+
+func (p *Provider) Cancel(ctx context.Context, ref provider.BuildRef) error {
+	path := fmt.Sprintf("/repos/%v/actions/runs/%v/cancel", p.Repo, ref.ID)
+	return p.do(ctx, http.MethodPost, path, http.StatusAccepted, nil)
+}
+
+func (p *Provider) Restart(ctx context.Context, ref provider.BuildRef) error {
+	path := fmt.Sprintf("/repos/%v/actions/runs/%v/rerun", p.Repo, ref.ID)
+	return p.do(ctx, http.MethodPost, path, http.StatusCreated, nil)
+}
+
+// compareResponse is the subset of a GitHub compare response travisqueue
+// needs. https://docs.github.com/en/rest/commits/commits#compare-two-commits
+type compareResponse struct {
+	Files []struct {
+		Filename string `json:"filename"`
+	} `json:"files"`
+}
+
+// ChangedFiles implements provider.ChangedFilesProvider by comparing ref's
+// head commit against its parent. Returns provider.ErrFilesUnavailable if
+// ref's run has no head_sha (shouldn't happen in practice, but the field
+// isn't documented as required).
+func (p *Provider) ChangedFiles(ctx context.Context, ref provider.BuildRef) ([]string, error) {
+	path := fmt.Sprintf("/repos/%v/actions/runs/%v", p.Repo, ref.ID)
+	var run workflowRun
+	if err := p.do(ctx, http.MethodGet, path, http.StatusOK, &run); err != nil {
+		return nil, err
+	}
+	if run.HeadSHA == "" {
+		return nil, provider.ErrFilesUnavailable
+	}
+	return p.compareFiles(ctx, run.HeadSHA+"~1..."+run.HeadSHA)
+}
+
+// compareFiles returns the files changed in basehead (a GitHub
+// "base...head" compare spec), caching the result in p.filesCache for the
+// lifetime of the process.
+func (p *Provider) compareFiles(ctx context.Context, basehead string) ([]string, error) {
+	if cached, ok := p.filesCache[basehead]; ok {
+		return cached, nil
+	}
+
+	path := fmt.Sprintf("/repos/%v/compare/%v", p.Repo, basehead)
+	var cmp compareResponse
+	if err := p.do(ctx, http.MethodGet, path, http.StatusOK, &cmp); err != nil {
+		return nil, err
+	}
+
+	files := make([]string, len(cmp.Files))
+	for i, f := range cmp.Files {
+		files[i] = f.Filename
+	}
+
+	if p.filesCache == nil {
+		p.filesCache = make(map[string][]string)
+	}
+	p.filesCache[basehead] = files
+
+	return files, nil
+}
+
+func (p *Provider) do(ctx context.Context, method, path string, expectStatus int, bodyValue interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.endpoint()+path, nil)
+	if err != nil {
+		return fmt.Errorf("github: creating request to %v: %w", path, err)
+	}
+	req.Header.Add("Accept", "application/vnd.github+json")
+	req.Header.Add("Authorization", "Bearer "+p.Token)
+
+	res, err := p.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("github: request to %v: %w", path, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != expectStatus {
+		return fmt.Errorf("github: %v %v: %v", method, path, res.Status)
+	}
+
+	if bodyValue != nil {
+		if err := json.NewDecoder(res.Body).Decode(bodyValue); err != nil {
+			return fmt.Errorf("github: decoding response from %v: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// toBuildRef maps a workflow run's status/conclusion onto the
+// provider-independent states in package provider.
+func toBuildRef(r workflowRun) provider.BuildRef {
+	state := provider.StateStarted
+	switch {
+	case r.Status != "completed":
+		state = provider.StateStarted
+	case r.Conclusion == "success":
+		state = provider.StatePassed
+	case r.Conclusion == "cancelled":
+		state = provider.StateCanceled
+	case r.Conclusion == "failure" || r.Conclusion == "timed_out" || r.Conclusion == "action_required":
+		state = provider.StateFailed
+	default:
+		state = provider.StateErrored
+	}
+
+	return provider.BuildRef{
+		ID:     strconv.FormatInt(r.ID, 10),
+		Number: strconv.Itoa(r.RunNumber),
+		State:  state,
+	}
+}
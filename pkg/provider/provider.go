@@ -0,0 +1,90 @@
+// Package provider defines a CI-system-agnostic interface for the
+// "one build per branch" queue logic in travisqueue, so that logic can run
+// unmodified against Travis CI, GitHub Actions, or LUCI Buildbucket.
+// See the travis, github, and buildbucket subpackages for implementations.
+package provider
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoBuilds is returned by Running, NewestFinished, and Newest when no
+// build matches.
+var ErrNoBuilds = errors.New("provider: no matching builds")
+
+// ErrFilesUnavailable is returned by ChangedFilesProvider.ChangedFiles when
+// a build's changed files can't be determined (e.g. its commit is no
+// longer available).
+var ErrFilesUnavailable = errors.New("provider: changed files unavailable")
+
+// Well-known, provider-independent build states. Implementations map their
+// native state vocabulary onto these so that callers can compare BuildRefs
+// across providers.
+const (
+	StateStarted  = "started"
+	StatePassed   = "passed"
+	StateFailed   = "failed"
+	StateErrored  = "errored"
+	StateCanceled = "canceled"
+)
+
+// BuildRef identifies a single build/run within a provider. ID must be a
+// base-10 integer string that increases with build recency, so that
+// callers can compare two BuildRefs from the same provider with a plain
+// numeric comparison; see the buildbucket package for how it satisfies
+// this for a CI system whose native IDs decrease over time.
+type BuildRef struct {
+	ID     string
+	Number string
+	State  string
+}
+
+// QueueKey identifies the queue a build belongs to: either a branch, for
+// builds triggered by a push, or a pull request number, for builds
+// triggered by a pull_request event. Exactly one of the two is set.
+type QueueKey struct {
+	Branch            string
+	PullRequestNumber int
+}
+
+// Provider is a CI system that can find, cancel, and restart builds for a
+// queue.
+type Provider interface {
+	// Self returns a BuildRef for the build travisqueue is currently
+	// running inside of, using only local environment data (no API call).
+	Self() BuildRef
+
+	// Running returns the builds currently running in key, earliest-started
+	// first. Returns ErrNoBuilds if none is running.
+	Running(ctx context.Context, key QueueKey) ([]BuildRef, error)
+
+	// NewestFinished returns the most recently finished (passed, failed,
+	// or errored) build in key. Returns ErrNoBuilds if none has finished.
+	NewestFinished(ctx context.Context, key QueueKey) (BuildRef, error)
+
+	// Newest returns the most recently created build in key, regardless
+	// of state. Returns ErrNoBuilds if there are none.
+	Newest(ctx context.Context, key QueueKey) (BuildRef, error)
+
+	// Get returns the current state of ref, for polling a build to
+	// completion after Cancel.
+	Get(ctx context.Context, ref BuildRef) (BuildRef, error)
+
+	// Cancel cancels the given build.
+	Cancel(ctx context.Context, ref BuildRef) error
+
+	// Restart restarts the given build.
+	Restart(ctx context.Context, ref BuildRef) error
+}
+
+// ChangedFilesProvider is an optional capability for path-scoped queues
+// (ONEBUILD_PATHS): providers that can report which files a build's commit
+// touched implement it. Callers should type-assert for it and treat a
+// provider that doesn't implement it the same as ErrFilesUnavailable,
+// falling back to a branch-wide queue.
+type ChangedFilesProvider interface {
+	// ChangedFiles returns the paths of files changed by ref's commit,
+	// relative to the repository root.
+	ChangedFiles(ctx context.Context, ref BuildRef) ([]string, error)
+}
@@ -0,0 +1,240 @@
+// Package travis implements provider.Provider on top of pkg/travisci.
+package travis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pulumi/travisqueue/pkg/provider"
+	"github.com/pulumi/travisqueue/pkg/travisci"
+)
+
+// Provider adapts a travisci.Client to provider.Provider for a single
+// repository and build.
+type Provider struct {
+	Client   *travisci.Client
+	RepoSlug string
+
+	// SelfID is the Travis build ID travisqueue is running inside of
+	// (TRAVIS_BUILD_ID).
+	SelfID int
+
+	// GitHubToken, if set, enables ChangedFiles by calling the GitHub REST
+	// API's compare endpoint, sent as an "Authorization: Bearer <GitHubToken>"
+	// header. The Travis API itself has no diff endpoint, so travisqueue
+	// relies on RepoSlug also being the repository's GitHub "owner/name"
+	// (true for any Travis repo backed by GitHub, which is effectively all
+	// of them on travis-ci.com).
+	GitHubToken string
+
+	// SelfCommitRange is TRAVIS_COMMIT_RANGE for the build travisqueue is
+	// running inside of, used to compute Self()'s own changed files
+	// without an extra Travis API call to look up its own commit.
+	SelfCommitRange string
+
+	HTTPClient *http.Client
+
+	filesCache map[string][]string
+}
+
+// New returns a Provider backed by client.
+func New(client *travisci.Client, repoSlug string, selfID int) *Provider {
+	return &Provider{Client: client, RepoSlug: repoSlug, SelfID: selfID}
+}
+
+func (p *Provider) httpClient() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (p *Provider) Self() provider.BuildRef {
+	return provider.BuildRef{ID: strconv.Itoa(p.SelfID)}
+}
+
+// maxRunningBuilds caps how many concurrently running builds Running will
+// ever report for a single queue; ONEBUILD_CONCURRENCY limits are expected
+// to be well below this.
+const maxRunningBuilds = 100
+
+func (p *Provider) Running(ctx context.Context, key provider.QueueKey) ([]provider.BuildRef, error) {
+	found, err := p.matchingBuilds(ctx, key, provider.StateStarted, "started_at", maxRunningBuilds)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, provider.ErrNoBuilds
+	}
+	return found, nil
+}
+
+func (p *Provider) NewestFinished(ctx context.Context, key provider.QueueKey) (provider.BuildRef, error) {
+	states := provider.StatePassed + "," + provider.StateFailed + "," + provider.StateErrored
+	return p.matchingBuild(ctx, key, states, "id:desc")
+}
+
+func (p *Provider) Newest(ctx context.Context, key provider.QueueKey) (provider.BuildRef, error) {
+	return p.matchingBuild(ctx, key, "", "id:desc")
+}
+
+func (p *Provider) matchingBuild(ctx context.Context, key provider.QueueKey, states, sortBy string) (provider.BuildRef, error) {
+	found, err := p.matchingBuilds(ctx, key, states, sortBy, 1)
+	if err != nil {
+		return provider.BuildRef{}, err
+	}
+	if len(found) == 0 {
+		return provider.BuildRef{}, provider.ErrNoBuilds
+	}
+	return found[0], nil
+}
+
+// matchingBuilds is a thin wrapper over Client.FindBuilds; Travis's own
+// state names ("started", "passed", "failed", "errored", "canceled") already
+// match the provider package's well-known states, so no translation is
+// needed beyond formatting the ID.
+func (p *Provider) matchingBuilds(ctx context.Context, key provider.QueueKey, states, sortBy string, limit int) ([]provider.BuildRef, error) {
+	q := travisci.BuildQuery{
+		State:  states,
+		SortBy: sortBy,
+		Limit:  limit,
+	}
+	if key.PullRequestNumber != 0 {
+		q.EventType = "pull_request"
+		q.PullRequestNumber = key.PullRequestNumber
+	} else {
+		q.EventType = "push"
+		q.Branch = key.Branch
+	}
+
+	found, err := p.Client.FindBuilds(ctx, p.RepoSlug, q)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]provider.BuildRef, len(found))
+	for i, b := range found {
+		refs[i] = toBuildRef(b)
+	}
+	return refs, nil
+}
+
+func (p *Provider) Get(ctx context.Context, ref provider.BuildRef) (provider.BuildRef, error) {
+	id, err := strconv.Atoi(ref.ID)
+	if err != nil {
+		return provider.BuildRef{}, err
+	}
+	b, err := p.Client.GetBuild(ctx, id)
+	if err != nil {
+		return provider.BuildRef{}, err
+	}
+	return toBuildRef(b), nil
+}
+
+func (p *Provider) Cancel(ctx context.Context, ref provider.BuildRef) error {
+	id, err := strconv.Atoi(ref.ID)
+	if err != nil {
+		return err
+	}
+	return p.Client.CancelBuild(ctx, id)
+}
+
+func (p *Provider) Restart(ctx context.Context, ref provider.BuildRef) error {
+	id, err := strconv.Atoi(ref.ID)
+	if err != nil {
+		return err
+	}
+	return p.Client.RestartBuild(ctx, id)
+}
+
+func toBuildRef(b travisci.Build) provider.BuildRef {
+	return provider.BuildRef{
+		ID:     strconv.Itoa(b.ID),
+		Number: b.Number,
+		State:  b.State,
+	}
+}
+
+// ChangedFiles implements provider.ChangedFilesProvider by comparing ref's
+// commit against its parent through the GitHub REST API's compare endpoint
+// (https://docs.github.com/en/rest/commits/commits#compare-two-commits),
+// since the Travis API doesn't expose diffs. Returns
+// provider.ErrFilesUnavailable if GitHubToken is unset or ref's commit
+// can't be determined.
+func (p *Provider) ChangedFiles(ctx context.Context, ref provider.BuildRef) ([]string, error) {
+	if p.GitHubToken == "" {
+		return nil, provider.ErrFilesUnavailable
+	}
+
+	if ref.ID == strconv.Itoa(p.SelfID) && p.SelfCommitRange != "" {
+		return p.compareFiles(ctx, p.SelfCommitRange)
+	}
+
+	id, err := strconv.Atoi(ref.ID)
+	if err != nil {
+		return nil, err
+	}
+	b, err := p.Client.GetBuild(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if b.Commit.SHA == "" {
+		return nil, provider.ErrFilesUnavailable
+	}
+	return p.compareFiles(ctx, b.Commit.SHA+"~1..."+b.Commit.SHA)
+}
+
+// compareResponse is the subset of a GitHub compare response travisqueue
+// needs. https://docs.github.com/en/rest/commits/commits#compare-two-commits
+type compareResponse struct {
+	Files []struct {
+		Filename string `json:"filename"`
+	} `json:"files"`
+}
+
+// compareFiles returns the files changed in basehead (a GitHub
+// "base...head" or "base..head" compare spec), caching the result in
+// p.filesCache for the lifetime of the process.
+func (p *Provider) compareFiles(ctx context.Context, basehead string) ([]string, error) {
+	if cached, ok := p.filesCache[basehead]; ok {
+		return cached, nil
+	}
+
+	url := fmt.Sprintf("https://api.github.com/repos/%v/compare/%v", p.RepoSlug, basehead)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("travis: creating request to %v: %w", url, err)
+	}
+	req.Header.Add("Accept", "application/vnd.github+json")
+	req.Header.Add("Authorization", "Bearer "+p.GitHubToken)
+
+	res, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("travis: request to %v: %w", url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("travis: comparing %v: %v", basehead, res.Status)
+	}
+
+	var cmp compareResponse
+	if err := json.NewDecoder(res.Body).Decode(&cmp); err != nil {
+		return nil, fmt.Errorf("travis: decoding compare response for %v: %w", basehead, err)
+	}
+
+	files := make([]string, len(cmp.Files))
+	for i, f := range cmp.Files {
+		files[i] = f.Filename
+	}
+
+	if p.filesCache == nil {
+		p.filesCache = make(map[string][]string)
+	}
+	p.filesCache[basehead] = files
+
+	return files, nil
+}
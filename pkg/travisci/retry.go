@@ -0,0 +1,109 @@
+package travisci
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how FindBuilds/CancelBuild/RestartBuild retry
+// transient failures (5xx and 429 responses).
+type RetryPolicy struct {
+	// MaxRetries is the number of retries attempted after the initial
+	// request. Zero means DefaultRetryPolicy.MaxRetries.
+	MaxRetries int
+
+	// BaseDelay is the delay before the first retry, doubled on each
+	// subsequent attempt up to MaxDelay. Zero means
+	// DefaultRetryPolicy.BaseDelay.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the computed exponential backoff delay. Zero means
+	// DefaultRetryPolicy.MaxDelay.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used when a Client's Retry field is the zero value.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 5,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   30 * time.Second,
+}
+
+func (r RetryPolicy) maxRetries() int {
+	if r.MaxRetries == 0 {
+		return DefaultRetryPolicy.MaxRetries
+	}
+	return r.MaxRetries
+}
+
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	base := r.BaseDelay
+	if base == 0 {
+		base = DefaultRetryPolicy.BaseDelay
+	}
+	max := r.MaxDelay
+	if max == 0 {
+		max = DefaultRetryPolicy.MaxDelay
+	}
+
+	delay := time.Duration(float64(base) * math.Pow(2, float64(attempt)))
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// retryableError marks an error from doOnce as eligible for retry, optionally
+// carrying a server-specified delay (from a Retry-After header).
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// isRetryable reports whether err is a retryableError, along with any
+// server-specified delay before retrying.
+func isRetryable(err error) (time.Duration, bool) {
+	var re *retryableError
+	if errors.As(err, &re) {
+		return re.retryAfter, true
+	}
+	return 0, false
+}
+
+// unwrapRetryable returns the underlying error of a retryableError, or err
+// unchanged if it isn't one.
+func unwrapRetryable(err error) error {
+	var re *retryableError
+	if errors.As(err, &re) {
+		return re.err
+	}
+	return err
+}
+
+// parseRetryAfter parses a Retry-After header value, which per RFC 7231 is
+// either a number of seconds or an HTTP-date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			return 0, false
+		}
+		return d, true
+	}
+	return 0, false
+}
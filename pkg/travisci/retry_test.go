@@ -0,0 +1,115 @@
+package travisci
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	r := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second}, // capped by MaxDelay
+	}
+	for _, c := range cases {
+		if got := r.backoff(c.attempt); got != c.want {
+			t.Errorf("backoff(%d) = %v, want %v", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffDefaults(t *testing.T) {
+	var r RetryPolicy
+	if got := r.backoff(0); got != DefaultRetryPolicy.BaseDelay {
+		t.Errorf("backoff(0) with zero-value policy = %v, want %v", got, DefaultRetryPolicy.BaseDelay)
+	}
+}
+
+func TestRetryPolicyMaxRetries(t *testing.T) {
+	if got := (RetryPolicy{}).maxRetries(); got != DefaultRetryPolicy.MaxRetries {
+		t.Errorf("maxRetries() with zero-value policy = %d, want %d", got, DefaultRetryPolicy.MaxRetries)
+	}
+	if got := (RetryPolicy{MaxRetries: 2}).maxRetries(); got != 2 {
+		t.Errorf("maxRetries() = %d, want 2", got)
+	}
+}
+
+func TestParseRetryAfterSeconds(t *testing.T) {
+	d, ok := parseRetryAfter("5")
+	if !ok || d != 5*time.Second {
+		t.Errorf("parseRetryAfter(%q) = %v, %v, want 5s, true", "5", d, ok)
+	}
+}
+
+func TestParseRetryAfterNegativeSeconds(t *testing.T) {
+	if _, ok := parseRetryAfter("-5"); ok {
+		t.Error("parseRetryAfter(-5) ok = true, want false")
+	}
+}
+
+func TestParseRetryAfterHTTPDate(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	d, ok := parseRetryAfter(future)
+	if !ok {
+		t.Fatalf("parseRetryAfter(%q) ok = false, want true", future)
+	}
+	if d <= 0 || d > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %v, want roughly 1h", future, d)
+	}
+}
+
+func TestParseRetryAfterPast(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if _, ok := parseRetryAfter(past); ok {
+		t.Error("parseRetryAfter(past date) ok = true, want false")
+	}
+}
+
+func TestParseRetryAfterEmpty(t *testing.T) {
+	if _, ok := parseRetryAfter(""); ok {
+		t.Error("parseRetryAfter(\"\") ok = true, want false")
+	}
+}
+
+func TestParseRetryAfterInvalid(t *testing.T) {
+	if _, ok := parseRetryAfter("not-a-valid-value"); ok {
+		t.Error("parseRetryAfter(garbage) ok = true, want false")
+	}
+}
+
+func TestIsRetryableAndUnwrap(t *testing.T) {
+	underlying := &APIError{Method: "GET", URL: "/x", StatusCode: 500, Status: "500 Internal Server Error"}
+	wrapped := &retryableError{err: underlying, retryAfter: 3 * time.Second}
+
+	d, ok := isRetryable(wrapped)
+	if !ok || d != 3*time.Second {
+		t.Errorf("isRetryable(wrapped) = %v, %v, want 3s, true", d, ok)
+	}
+	if unwrapRetryable(wrapped) != underlying {
+		t.Error("unwrapRetryable(wrapped) did not return the underlying error")
+	}
+
+	if _, ok := isRetryable(underlying); ok {
+		t.Error("isRetryable(plain error) = true, want false")
+	}
+	if unwrapRetryable(underlying) != underlying {
+		t.Error("unwrapRetryable(plain error) should return err unchanged")
+	}
+}
+
+func TestRetryDelayPrefersRetryAfter(t *testing.T) {
+	r := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	if got := retryDelay(5*time.Second, r, 0); got != 5*time.Second {
+		t.Errorf("retryDelay with Retry-After = %v, want 5s", got)
+	}
+	if got := retryDelay(0, r, 0); got != r.backoff(0) {
+		t.Errorf("retryDelay without Retry-After = %v, want %v", got, r.backoff(0))
+	}
+}
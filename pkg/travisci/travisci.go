@@ -0,0 +1,270 @@
+// Package travisci is a minimal client for the Travis CI v3 API
+// (https://developer.travis-ci.com), covering the subset of endpoints
+// needed to find, cancel, and restart builds.
+package travisci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrNotFound is returned when the Travis API responds 404.
+var ErrNotFound = errors.New("travisci: not found")
+
+// ErrUnauthorized is returned when the Travis API responds 401 or 403.
+var ErrUnauthorized = errors.New("travisci: unauthorized")
+
+// APIError is returned for any other unexpected status code.
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("travisci: %v %v: %v", e.Method, e.URL, e.Status)
+}
+
+// Client is a Travis CI v3 API client.
+type Client struct {
+	// Endpoint is the base URL of the Travis API, e.g. https://api.travis-ci.com.
+	Endpoint *url.URL
+
+	// Token is the Travis API token, sent as an "Authorization: token <Token>" header.
+	Token string
+
+	// HTTPClient is used to make requests. If nil, a client with a default
+	// per-request Timeout is used.
+	HTTPClient *http.Client
+
+	// Retry controls retry-with-backoff behavior for transient errors
+	// (5xx and 429 responses). The zero value uses DefaultRetryPolicy.
+	Retry RetryPolicy
+}
+
+// NewClient returns a Client with sensible defaults for HTTPClient and Retry.
+func NewClient(endpoint *url.URL, token string) *Client {
+	return &Client{
+		Endpoint: endpoint,
+		Token:    token,
+		HTTPClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		Retry: DefaultRetryPolicy,
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// BuildQuery selects and orders builds returned by FindBuilds.
+// https://developer.travis-ci.com/resource/builds#find
+type BuildQuery struct {
+	// EventType filters by build.event_type, e.g. "push" or "pull_request".
+	EventType string
+
+	// Branch filters by build.branch. Ignored if empty.
+	Branch string
+
+	// PullRequestNumber filters by build.pull_request. Ignored if zero.
+	PullRequestNumber int
+
+	// State filters by build.state, e.g. "started" or "passed,failed,errored".
+	// Matches any state if empty.
+	State string
+
+	// SortBy is passed through as sort_by, e.g. "started_at" or "id:desc".
+	SortBy string
+
+	// Limit caps the number of builds returned. Defaults to 1 if zero.
+	Limit int
+}
+
+// Build is a Travis CI build.
+// https://developer.travis-ci.com/resource/build#Build
+// This definition only includes fields travisqueue needs.
+type Build struct {
+	ID     int    `json:"id"`
+	Number string `json:"number"`
+	State  string `json:"state"`
+
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+
+	// Duration is the build duration in seconds.
+	Duration int `json:"duration"`
+
+	// PullRequestNumber is nonzero for builds triggered by a pull_request event.
+	PullRequestNumber int `json:"pull_request_number"`
+
+	Repository Repository `json:"repository"`
+	Commit     Commit     `json:"commit"`
+}
+
+// Repository identifies the repository a build belongs to.
+type Repository struct {
+	ID   int    `json:"id"`
+	Slug string `json:"slug"`
+}
+
+// Commit is the commit a build ran against.
+type Commit struct {
+	SHA     string `json:"sha"`
+	Branch  string `json:"branch"`
+	Message string `json:"message"`
+}
+
+// builds is the envelope Travis wraps build lists in.
+// https://developer.travis-ci.com/resource/builds#Builds
+type builds struct {
+	Builds []Build `json:"builds"`
+}
+
+// FindBuilds returns the builds in repoSlug matching q, most relevant first
+// as ordered by q.SortBy.
+func (c *Client) FindBuilds(ctx context.Context, repoSlug string, q BuildQuery) ([]Build, error) {
+	vs := url.Values{}
+	if q.EventType != "" {
+		vs.Add("build.event_type", q.EventType)
+	}
+	if q.Branch != "" {
+		vs.Add("build.branch", q.Branch)
+	}
+	if q.PullRequestNumber != 0 {
+		vs.Add("build.pull_request", fmt.Sprint(q.PullRequestNumber))
+	}
+	if q.State != "" {
+		vs.Add("build.state", q.State)
+	}
+	if q.SortBy != "" {
+		vs.Add("sort_by", q.SortBy)
+	}
+	limit := q.Limit
+	if limit == 0 {
+		limit = 1
+	}
+	vs.Add("limit", fmt.Sprint(limit))
+
+	path := fmt.Sprintf("/repo/%v/builds?%v", url.PathEscape(repoSlug), vs.Encode())
+
+	var b builds
+	if err := c.do(ctx, http.MethodGet, path, http.StatusOK, &b); err != nil {
+		return nil, err
+	}
+	return b.Builds, nil
+}
+
+// CancelBuild issues a cancel request for the build with the given id.
+// It does not wait for the build to actually stop.
+func (c *Client) CancelBuild(ctx context.Context, id int) error {
+	path := fmt.Sprintf("/build/%v/cancel", id)
+	return c.do(ctx, http.MethodPost, path, http.StatusAccepted, nil)
+}
+
+// RestartBuild issues a restart request for the build with the given id.
+func (c *Client) RestartBuild(ctx context.Context, id int) error {
+	path := fmt.Sprintf("/build/%v/restart", id)
+	return c.do(ctx, http.MethodPost, path, http.StatusAccepted, nil)
+}
+
+// GetBuild fetches a single build by id, including its commit.
+func (c *Client) GetBuild(ctx context.Context, id int) (Build, error) {
+	path := fmt.Sprintf("/build/%v?include=build.commit", id)
+	var b Build
+	if err := c.do(ctx, http.MethodGet, path, http.StatusOK, &b); err != nil {
+		return Build{}, err
+	}
+	return b, nil
+}
+
+// do performs a single logical API call, retrying transient failures
+// according to c.Retry, and decodes the response body into bodyValue if
+// it is non-nil.
+func (c *Client) do(ctx context.Context, method, path string, expectStatus int, bodyValue interface{}) error {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return fmt.Errorf("travisci: parsing path %v: %w", path, err)
+	}
+	reqURL := c.Endpoint.ResolveReference(ref)
+
+	retry := c.Retry
+	if retry == (RetryPolicy{}) {
+		retry = DefaultRetryPolicy
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.doOnce(ctx, method, reqURL.String(), expectStatus, bodyValue)
+		if err == nil {
+			return nil
+		}
+
+		retryAfter, retryable := isRetryable(err)
+		if !retryable || attempt >= retry.maxRetries() {
+			return unwrapRetryable(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryDelay(retryAfter, retry, attempt)):
+		}
+	}
+}
+
+func retryDelay(retryAfter time.Duration, retry RetryPolicy, attempt int) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	return retry.backoff(attempt)
+}
+
+func (c *Client) doOnce(ctx context.Context, method, url string, expectStatus int, bodyValue interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return fmt.Errorf("travisci: creating request to %v: %w", url, err)
+	}
+
+	req.Header.Add("Travis-API-Version", "3")
+	req.Header.Add("Authorization", "token "+c.Token)
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("travisci: request to %v: %w", url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != expectStatus {
+		switch res.StatusCode {
+		case http.StatusNotFound:
+			return ErrNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return ErrUnauthorized
+		}
+		apiErr := &APIError{Method: method, URL: url, StatusCode: res.StatusCode, Status: res.Status}
+		if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+			if d, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+				return &retryableError{err: apiErr, retryAfter: d}
+			}
+			return &retryableError{err: apiErr}
+		}
+		return apiErr
+	}
+
+	if bodyValue != nil {
+		if err := json.NewDecoder(res.Body).Decode(bodyValue); err != nil {
+			return fmt.Errorf("travisci: decoding response from %v as %T: %w", url, bodyValue, err)
+		}
+	}
+
+	return nil
+}
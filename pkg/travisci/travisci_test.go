@@ -0,0 +1,145 @@
+package travisci
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	endpoint, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("parsing test server URL: %v", err)
+	}
+	return &Client{
+		Endpoint:   endpoint,
+		Token:      "test-token",
+		HTTPClient: srv.Client(),
+		Retry:      RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond},
+	}
+}
+
+func TestFindBuildsRetriesOn500ThenSucceeds(t *testing.T) {
+	var attempts int32
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"builds":[{"id":42,"number":"7","state":"started"}]}`))
+	})
+
+	builds, err := c.FindBuilds(context.Background(), "owner/repo", BuildQuery{})
+	if err != nil {
+		t.Fatalf("FindBuilds: %v", err)
+	}
+	if len(builds) != 1 || builds[0].ID != 42 {
+		t.Errorf("FindBuilds = %+v, want one build with ID 42", builds)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("attempts = %d, want 3 (two failures then a success)", got)
+	}
+}
+
+func TestFindBuildsHonorsRetryAfter(t *testing.T) {
+	var attempts int32
+	var firstRetryAt, secondAttemptAt time.Time
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&attempts, 1)
+		if n == 1 {
+			firstRetryAt = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		secondAttemptAt = time.Now()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"builds":[]}`))
+	})
+
+	if _, err := c.FindBuilds(context.Background(), "owner/repo", BuildQuery{}); err != nil {
+		t.Fatalf("FindBuilds: %v", err)
+	}
+	if atomic.LoadInt32(&attempts) != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts)
+	}
+	// The policy's backoff is capped at 5ms, so a gap of at least 1s can
+	// only come from the Retry-After header.
+	if gap := secondAttemptAt.Sub(firstRetryAt); gap < time.Second {
+		t.Errorf("retry came %v after the first attempt, want at least 1s per Retry-After", gap)
+	}
+}
+
+func TestFindBuildsGivesUpAfterMaxRetries(t *testing.T) {
+	var attempts int32
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	_, err := c.FindBuilds(context.Background(), "owner/repo", BuildQuery{})
+	if err == nil {
+		t.Fatal("FindBuilds succeeded, want an error after exhausting retries")
+	}
+	if got := atomic.LoadInt32(&attempts); got != int32(c.Retry.MaxRetries)+1 {
+		t.Errorf("attempts = %d, want %d (initial + MaxRetries retries)", got, c.Retry.MaxRetries+1)
+	}
+}
+
+func TestFindBuildsDoesNotRetryNotFound(t *testing.T) {
+	var attempts int32
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	_, err := c.FindBuilds(context.Background(), "owner/repo", BuildQuery{})
+	if err != ErrNotFound {
+		t.Fatalf("FindBuilds error = %v, want ErrNotFound", err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("attempts = %d, want 1 (404 is not retryable)", got)
+	}
+}
+
+func TestFindBuildsDoesNotRetryUnauthorized(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	_, err := c.FindBuilds(context.Background(), "owner/repo", BuildQuery{})
+	if err != ErrUnauthorized {
+		t.Fatalf("FindBuilds error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestCancelBuildSendsExpectedRequest(t *testing.T) {
+	var gotMethod, gotPath, gotAuth string
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	if err := c.CancelBuild(context.Background(), 42); err != nil {
+		t.Fatalf("CancelBuild: %v", err)
+	}
+	if gotMethod != http.MethodPost || gotPath != "/build/42/cancel" {
+		t.Errorf("got %v %v, want POST /build/42/cancel", gotMethod, gotPath)
+	}
+	if gotAuth != "token test-token" {
+		t.Errorf("Authorization header = %q, want %q", gotAuth, "token test-token")
+	}
+}